@@ -0,0 +1,71 @@
+package ecspresso
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/codedeploy"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/pkg/errors"
+)
+
+// Config is the ecspresso.yml configuration, as much of it as the code in
+// this tree reads directly. RetryCount overrides the number of retries the
+// ECS/CodeDeploy/ApplicationAutoScaling clients perform on throttling before
+// giving up (see retry.go); it defaults to defaultRetryCount when zero, and
+// can also be overridden per-service in the config.
+type Config struct {
+	Region             string
+	Cluster            string
+	Service            string
+	TaskDefinitionPath string
+	RetryCount         int
+	Hooks              map[HookEvent][]HookConfig
+	CodeDeployHooks    CodeDeployHookConfig
+}
+
+// App wires an AWS session and its ECS/CodeDeploy/ApplicationAutoScaling/
+// IAM/ECR/SSM/SecretsManager/CloudWatchLogs clients to a single configured
+// service.
+type App struct {
+	Service string
+	Cluster string
+
+	config                 *Config
+	ecs                    *ecs.ECS
+	codedeploy             *codedeploy.CodeDeploy
+	applicationautoscaling *applicationautoscaling.ApplicationAutoScaling
+	iam                    *iam.IAM
+	ecr                    *ecr.ECR
+	ssm                    *ssm.SSM
+	secretsmanager         *secretsmanager.SecretsManager
+	cwlogs                 *cloudwatchlogs.CloudWatchLogs
+}
+
+// NewApp builds an App from conf: one AWS session shared by all clients, so
+// that --retry-count (conf.RetryCount) governs the backoff ECS, CodeDeploy,
+// and ApplicationAutoScaling apply to their own retryable calls, not just
+// the ad-hoc polling loops in this package.
+func NewApp(conf *Config) (*App, error) {
+	sess, err := session.NewSession(retryerConfig(conf.RetryCount))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create aws session")
+	}
+	return &App{
+		Service:                conf.Service,
+		Cluster:                conf.Cluster,
+		config:                 conf,
+		ecs:                    ecs.New(sess),
+		codedeploy:             codedeploy.New(sess),
+		applicationautoscaling: applicationautoscaling.New(sess),
+		iam:                    iam.New(sess),
+		ecr:                    ecr.New(sess),
+		ssm:                    ssm.New(sess),
+		secretsmanager:         secretsmanager.New(sess),
+		cwlogs:                 cloudwatchlogs.New(sess),
+	}, nil
+}
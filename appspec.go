@@ -0,0 +1,65 @@
+package ecspresso
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// CodeDeployHookConfig maps CodeDeploy ECS blue/green lifecycle events to
+// the Lambda function ARN that validates that step, declared per-service in
+// the ecspresso config, e.g.:
+//
+//	codeDeployHooks:
+//	  BeforeInstall: arn:aws:lambda:...:function:before-install
+//	  AfterAllowTestTraffic: arn:aws:lambda:...:function:after-test-traffic
+type CodeDeployHookConfig map[string]string
+
+// codeDeployHookOrder is the fixed order CodeDeploy expects lifecycle hooks
+// to appear in the AppSpec's Hooks block.
+var codeDeployHookOrder = []string{
+	"BeforeInstall",
+	"AfterInstall",
+	"AfterAllowTestTraffic",
+	"BeforeAllowTraffic",
+	"AfterAllowTraffic",
+}
+
+// buildAppSpec renders the YAML AppSpec content for a CodeDeploy ECS
+// deployment: the target task definition, a LoadBalancerInfo entry naming
+// the container/port CodeDeploy should shift traffic to, and a Hooks block
+// for any configured lifecycle Lambda functions.
+//
+// The AppSpec itself only ever takes a single LoadBalancerInfo entry, even
+// for blue/green deployments that validate against separate test and prod
+// listeners: the test and prod target groups for that case are configured
+// as a TargetGroupPairInfo on the CodeDeploy deployment group (see
+// findDeployment), not listed here. ECS services normally expose at most
+// one LoadBalancer in the CODE_DEPLOY controller, but if more than one is
+// attached they must all point at the same container/port, so it's safe to
+// take the first.
+func buildAppSpec(taskDefinitionArn string, lbs []*ecs.LoadBalancer, hooks CodeDeployHookConfig) (string, error) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "version: 1\nResources:\n- TargetService:\n    Type: AWS::ECS::Service\n    Properties:\n      TaskDefinition: %q\n", taskDefinitionArn)
+
+	if len(lbs) > 0 {
+		fmt.Fprintf(&b, "      LoadBalancerInfo:\n        ContainerName: %s\n        ContainerPort: %d\n",
+			aws.StringValue(lbs[0].ContainerName), aws.Int64Value(lbs[0].ContainerPort))
+	}
+
+	if len(hooks) > 0 {
+		b.WriteString("Hooks:\n")
+		for _, event := range codeDeployHookOrder {
+			arn, ok := hooks[event]
+			if !ok || arn == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "- %s: %q\n", event, arn)
+		}
+	}
+
+	return b.String(), nil
+}
@@ -15,25 +15,12 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrDeploymentRolledBack indicates that ECS rolled a deployment back, so
+// callers can distinguish a rollback from a plain stabilization timeout.
+var ErrDeploymentRolledBack = errors.New("deployment was rolled back")
+
 const (
 	CodeDeployConsoleURLFmt = "https://%s.console.aws.amazon.com/codesuite/codedeploy/deployments/%s?region=%s"
-	AppSpecFmtWithLB        = `version: 1
-Resources:
-- TargetService:
-    Type: AWS::ECS::Service
-    Properties:
-      TaskDefinition: "%s"
-      LoadBalancerInfo:
-        ContainerName: %s
-        ContainerPort: %d
-`
-	AppSpecFmtWithoutLB = `version: 1
-Resources:
-- TargetService:
-    Type: AWS::ECS::Service
-    Properties:
-      TaskDefinition: "%s"
-`
 )
 
 func (d *App) Deploy(opt DeployOption) error {
@@ -66,6 +53,9 @@ func (d *App) Deploy(opt DeployOption) error {
 		if *opt.DryRun {
 			d.Log("task definition:", td.String())
 		} else {
+			if err := d.runHooks(ctx, HookBeforeRegister, "", ""); err != nil {
+				return errors.Wrap(err, "before_register hook failed")
+			}
 			newTd, err := d.RegisterTaskDefinition(ctx, td)
 			if err != nil {
 				return errors.Wrap(err, "failed to register task definition")
@@ -88,6 +78,10 @@ func (d *App) Deploy(opt DeployOption) error {
 		}
 	}
 
+	if err := d.runHooks(ctx, HookBeforeDeploy, tdArn, ""); err != nil {
+		return errors.Wrap(err, "before_deploy hook failed")
+	}
+
 	// detect controller
 	if dc := sv.DeploymentController; dc != nil {
 		switch t := *dc.Type; t {
@@ -99,7 +93,7 @@ func (d *App) Deploy(opt DeployOption) error {
 	}
 
 	// rolling deploy (ECS internal)
-	if err := d.UpdateService(ctx, tdArn, count, *opt.ForceNewDeployment, sv); err != nil {
+	if err := d.UpdateService(ctx, tdArn, count, *opt.ForceNewDeployment, sv, opt); err != nil {
 		return errors.Wrap(err, "failed to update service")
 	}
 
@@ -110,14 +104,24 @@ func (d *App) Deploy(opt DeployOption) error {
 
 	time.Sleep(delayForServiceChanged) // wait for service updated
 	if err := d.WaitServiceStable(ctx, time.Now()); err != nil {
+		if hookErr := d.runHooks(ctx, HookOnFailure, tdArn, ""); hookErr != nil {
+			d.Log("on_failure hook failed:", hookErr)
+		}
+		if d.deploymentWasRolledBack(ctx, tdArn) {
+			return errors.Wrap(ErrDeploymentRolledBack, "deployment was rolled back by the circuit breaker or an alarm")
+		}
 		return errors.Wrap(err, "failed to wait service stable")
 	}
 
+	if err := d.runHooks(ctx, HookAfterStable, tdArn, ""); err != nil {
+		return errors.Wrap(err, "after_stable hook failed")
+	}
+
 	d.Log("Service is stable now. Completed!")
 	return nil
 }
 
-func (d *App) UpdateService(ctx context.Context, taskDefinitionArn string, count *int64, force bool, sv *ecs.Service) error {
+func (d *App) UpdateService(ctx context.Context, taskDefinitionArn string, count *int64, force bool, sv *ecs.Service, opt DeployOption) error {
 	msg := "Updating service"
 	if force {
 		msg = msg + " with force new deployment"
@@ -136,11 +140,80 @@ func (d *App) UpdateService(ctx context.Context, taskDefinitionArn string, count
 			NetworkConfiguration:          sv.NetworkConfiguration,
 			HealthCheckGracePeriodSeconds: sv.HealthCheckGracePeriodSeconds,
 			PlatformVersion:               sv.PlatformVersion,
+			DeploymentConfiguration:       deploymentConfigurationFor(sv, opt),
 		},
 	)
 	return err
 }
 
+// deploymentWasRolledBack re-describes the service after WaitServiceStable
+// gives up, and reports whether the deployment to tdArn was actually rolled
+// back by the circuit breaker or a CloudWatch alarm, rather than just timing
+// out. ECS marks the rolled-back-from deployment FAILED with a
+// RolloutStateReason naming the circuit breaker or alarm, and runs the
+// rollback as a new PRIMARY deployment back to the previous task definition.
+func (d *App) deploymentWasRolledBack(ctx context.Context, tdArn string) bool {
+	sv, err := d.DescribeServiceStatus(ctx, 0)
+	if err != nil {
+		return false
+	}
+	for _, dp := range sv.Deployments {
+		if aws.StringValue(dp.TaskDefinition) != tdArn {
+			continue
+		}
+		if aws.StringValue(dp.RolloutState) != ecs.DeploymentRolloutStateFailed {
+			continue
+		}
+		reason := strings.ToLower(aws.StringValue(dp.RolloutStateReason))
+		if strings.Contains(reason, "circuit breaker") || strings.Contains(reason, "rollback") || strings.Contains(reason, "alarm") {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentConfigurationFor builds a DeploymentConfiguration that carries
+// over the service's existing percent settings while applying the
+// circuit-breaker and CloudWatch alarm based auto-rollback settings
+// requested on the CLI (--rollback-on-failure / --rollback-alarms).
+func deploymentConfigurationFor(sv *ecs.Service, opt DeployOption) *ecs.DeploymentConfiguration {
+	rollback := opt.RollbackOnFailure != nil && *opt.RollbackOnFailure
+	var alarmNames []string
+	if opt.RollbackAlarms != nil && *opt.RollbackAlarms != "" {
+		alarmNames = strings.Split(*opt.RollbackAlarms, ",")
+	}
+	if !rollback && len(alarmNames) == 0 {
+		if sv.DeploymentConfiguration != nil {
+			return sv.DeploymentConfiguration
+		}
+		return nil
+	}
+
+	dc := &ecs.DeploymentConfiguration{}
+	if sv.DeploymentConfiguration != nil {
+		dc.MaximumPercent = sv.DeploymentConfiguration.MaximumPercent
+		dc.MinimumHealthyPercent = sv.DeploymentConfiguration.MinimumHealthyPercent
+	}
+	if rollback {
+		dc.DeploymentCircuitBreaker = &ecs.DeploymentCircuitBreaker{
+			Enable:   aws.Bool(true),
+			Rollback: aws.Bool(true),
+		}
+	}
+	if len(alarmNames) > 0 {
+		names := make([]*string, 0, len(alarmNames))
+		for _, n := range alarmNames {
+			names = append(names, aws.String(strings.TrimSpace(n)))
+		}
+		dc.Alarms = &ecs.DeploymentAlarms{
+			AlarmNames: names,
+			Enable:     aws.Bool(true),
+			Rollback:   aws.Bool(true),
+		}
+	}
+	return dc
+}
+
 func (d *App) DeployByCodeDeploy(ctx context.Context, taskDefinitionArn string, count *int64, sv *ecs.Service, opt DeployOption) error {
 	if *sv.DesiredCount != *count {
 		d.Log("updating desired count to", *count)
@@ -157,16 +230,9 @@ func (d *App) DeployByCodeDeploy(ctx context.Context, taskDefinitionArn string,
 		}
 	}
 
-	var appSpec string
-	if sv.LoadBalancers != nil && len(sv.LoadBalancers) > 0 {
-		appSpec = fmt.Sprintf(
-			AppSpecFmtWithLB,
-			taskDefinitionArn,
-			*sv.LoadBalancers[0].ContainerName,
-			*sv.LoadBalancers[0].ContainerPort,
-		)
-	} else {
-		appSpec = fmt.Sprintf(AppSpecFmtWithoutLB, taskDefinitionArn)
+	appSpec, err := buildAppSpec(taskDefinitionArn, sv.LoadBalancers, d.config.CodeDeployHooks)
+	if err != nil {
+		return errors.Wrap(err, "failed to build AppSpec")
 	}
 	d.DebugLog("appSpecContent:", appSpec)
 
@@ -200,6 +266,9 @@ func (d *App) DeployByCodeDeploy(ctx context.Context, taskDefinitionArn string,
 
 	res, err := d.codedeploy.CreateDeploymentWithContext(ctx, dd)
 	if err != nil {
+		if hookErr := d.runHooks(ctx, HookOnFailure, taskDefinitionArn, ""); hookErr != nil {
+			d.Log("on_failure hook failed:", hookErr)
+		}
 		return errors.Wrap(err, "failed to create deployment")
 	}
 	id := *res.DeploymentId
@@ -217,6 +286,20 @@ func (d *App) DeployByCodeDeploy(ctx context.Context, taskDefinitionArn string,
 			d.Log("Couldn't open URL", u)
 		}
 	}
+
+	d.Log("Waiting for the CodeDeploy deployment to become successful...")
+	if err := d.codedeploy.WaitUntilDeploymentSuccessfulWithContext(ctx, &codedeploy.GetDeploymentInput{
+		DeploymentId: res.DeploymentId,
+	}); err != nil {
+		if hookErr := d.runHooks(ctx, HookOnFailure, taskDefinitionArn, id); hookErr != nil {
+			d.Log("on_failure hook failed:", hookErr)
+		}
+		return errors.Wrap(err, "CodeDeploy deployment did not become successful")
+	}
+
+	if err := d.runHooks(ctx, HookAfterStable, taskDefinitionArn, id); err != nil {
+		return errors.Wrap(err, "after_stable hook failed")
+	}
 	return nil
 }
 
@@ -0,0 +1,124 @@
+package ecspresso
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"plugin"
+
+	"github.com/pkg/errors"
+)
+
+// HookEvent identifies a point in the deploy lifecycle at which hooks run.
+type HookEvent string
+
+const (
+	HookBeforeRegister HookEvent = "before_register"
+	HookBeforeDeploy   HookEvent = "before_deploy"
+	HookAfterStable    HookEvent = "after_stable"
+	HookOnFailure      HookEvent = "on_failure"
+)
+
+// HookConfig is one hook to run for a given HookEvent, declared in the
+// ecspresso config as either a shell command or a Go plugin, e.g.:
+//
+//	hooks:
+//	  before_deploy:
+//	    - command: ["./bin/notify.sh", "deploying"]
+//	  after_stable:
+//	    - plugin: "./bin/warmup.so"
+//
+// Exactly one of Command or Plugin should be set; Command takes precedence
+// if both are.
+type HookConfig struct {
+	Command []string `yaml:"command,omitempty" json:"command,omitempty"`
+	Plugin  string   `yaml:"plugin,omitempty" json:"plugin,omitempty"`
+}
+
+// HookContext is the information made available to a hook: as environment
+// variables (ECSPRESSO_<KEY>) and JSON on stdin for a command hook, or as
+// the argument to Run for a plugin hook.
+type HookContext struct {
+	Event          HookEvent `json:"event"`
+	TaskDefinition string    `json:"task_definition_arn"`
+	DeploymentID   string    `json:"deployment_id,omitempty"`
+	Service        string    `json:"service"`
+	Cluster        string    `json:"cluster"`
+	Region         string    `json:"region"`
+}
+
+func (c HookContext) env() []string {
+	return []string{
+		"ECSPRESSO_EVENT=" + string(c.Event),
+		"ECSPRESSO_TASK_DEFINITION_ARN=" + c.TaskDefinition,
+		"ECSPRESSO_DEPLOYMENT_ID=" + c.DeploymentID,
+		"ECSPRESSO_SERVICE=" + c.Service,
+		"ECSPRESSO_CLUSTER=" + c.Cluster,
+		"ECSPRESSO_REGION=" + c.Region,
+	}
+}
+
+// runHooks runs every hook configured for event in order, aborting (and
+// returning an error) on the first command that exits non-zero.
+func (d *App) runHooks(ctx context.Context, event HookEvent, tdArn, deploymentID string) error {
+	hooks := d.config.Hooks[event]
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	hc := HookContext{
+		Event:          event,
+		TaskDefinition: tdArn,
+		DeploymentID:   deploymentID,
+		Service:        d.Service,
+		Cluster:        d.Cluster,
+		Region:         d.config.Region,
+	}
+	stdin, err := json.Marshal(hc)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal hook context")
+	}
+
+	for _, h := range hooks {
+		switch {
+		case len(h.Command) > 0:
+			d.Log(string(event), "hook:", h.Command)
+			cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+			cmd.Env = append(os.Environ(), hc.env()...)
+			cmd.Stdin = bytes.NewReader(stdin)
+			out, err := cmd.CombinedOutput()
+			if len(out) > 0 {
+				d.Log(string(event), "hook output:", string(out))
+			}
+			if err != nil {
+				return errors.Wrapf(err, "%s hook %v failed", event, h.Command)
+			}
+		case h.Plugin != "":
+			d.Log(string(event), "plugin hook:", h.Plugin)
+			if err := runPluginHook(ctx, h.Plugin, hc); err != nil {
+				return errors.Wrapf(err, "%s plugin hook %s failed", event, h.Plugin)
+			}
+		}
+	}
+	return nil
+}
+
+// runPluginHook loads a Go plugin built with `go build -buildmode=plugin`
+// and calls its exported Run(context.Context, HookContext) error symbol.
+func runPluginHook(ctx context.Context, path string, hc HookContext) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open plugin")
+	}
+	sym, err := p.Lookup("Run")
+	if err != nil {
+		return errors.Wrap(err, "plugin does not export Run")
+	}
+	run, ok := sym.(func(context.Context, HookContext) error)
+	if !ok {
+		return errors.New(`plugin Run must have signature func(context.Context, ecspresso.HookContext) error`)
+	}
+	return run(ctx, hc)
+}
@@ -0,0 +1,47 @@
+package ecspresso
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+)
+
+// defaultRetryCount is the number of retries ECS/CodeDeploy/Application
+// Auto Scaling clients perform by default when --retry-count is not set.
+// Long WaitServiceStable polls otherwise die on transient throttling from
+// DescribeServices/DescribeTaskDefinition in busy accounts.
+const defaultRetryCount = 10
+
+// retryerConfig returns an aws.Config with MaxRetries set to count (falling
+// back to defaultRetryCount when count is 0) and an exponential backoff with
+// jitter. Pass this to session.NewSession alongside the other aws.Configs
+// when App constructs its ECS/CodeDeploy/ApplicationAutoScaling clients, so
+// --retry-count (and a per-service config override) governs all three.
+func retryerConfig(count int) *aws.Config {
+	if count == 0 {
+		count = defaultRetryCount
+	}
+	return aws.NewConfig().
+		WithMaxRetries(count).
+		WithRequestRetryer(client.DefaultRetryer{
+			NumMaxRetries:    count,
+			MinRetryDelay:    200 * time.Millisecond,
+			MinThrottleDelay: 500 * time.Millisecond,
+		})
+}
+
+// isThrottled reports whether err is a retryable throttling error, used by
+// callers that poll outside of the SDK's own retryer (e.g. the tailTaskLogs
+// loop in run.go). aws-sdk-go v1 surfaces service errors as awserr.Error,
+// not request.Error, so that's what we need to unwrap here.
+func isThrottled(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+			return true
+		}
+	}
+	return false
+}
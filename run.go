@@ -0,0 +1,246 @@
+package ecspresso
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+const logPollInterval = 3 * time.Second
+
+// RunTask registers (or reuses) a task definition and runs it as a one-off
+// task, optionally waiting for it to stop and tailing its logs. This covers
+// workflows like running a DB migration before a deploy.
+func (d *App) RunTask(opt RunTaskOption) error {
+	ctx, cancel := d.Start()
+	defer cancel()
+
+	d.Log("Running task")
+	sv, err := d.DescribeServiceStatus(ctx, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to describe service status")
+	}
+
+	var tdArn string
+	if *opt.SkipTaskDefinition {
+		tdArn = *sv.TaskDefinition
+	} else {
+		td, err := d.LoadTaskDefinition(d.config.TaskDefinitionPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to load task definition")
+		}
+		newTd, err := d.RegisterTaskDefinition(ctx, td)
+		if err != nil {
+			return errors.Wrap(err, "failed to register task definition")
+		}
+		tdArn = *newTd.TaskDefinitionArn
+	}
+
+	if opt.ContainerName == nil || *opt.ContainerName == "" {
+		tdOut, err := d.ecs.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(tdArn),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to describe task definition")
+		}
+		if cds := tdOut.TaskDefinition.ContainerDefinitions; len(cds) > 0 {
+			opt.ContainerName = cds[0].Name
+		}
+	}
+
+	in := &ecs.RunTaskInput{
+		Cluster:              aws.String(d.Cluster),
+		TaskDefinition:       aws.String(tdArn),
+		Count:                aws.Int64(1),
+		LaunchType:           sv.LaunchType,
+		NetworkConfiguration: sv.NetworkConfiguration,
+		PlatformVersion:      sv.PlatformVersion,
+		Overrides:            taskOverrideFor(opt),
+	}
+	if opt.StartedBy != nil && *opt.StartedBy != "" {
+		in.StartedBy = opt.StartedBy
+	}
+	d.DebugLog("run task input:", in.String())
+
+	out, err := d.ecs.RunTaskWithContext(ctx, in)
+	if err != nil {
+		return errors.Wrap(err, "failed to run task")
+	}
+	if len(out.Failures) > 0 {
+		return fmt.Errorf("failed to run task: %s", out.Failures[0].String())
+	}
+	task := out.Tasks[0]
+	d.Log("Task is running:", *task.TaskArn)
+
+	if !*opt.Wait {
+		return nil
+	}
+
+	var tailStop, tailDone chan struct{}
+	if *opt.TailLogs {
+		tailStop, tailDone = make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(tailDone)
+			d.tailTaskLogs(ctx, tdArn, aws.StringValue(opt.ContainerName), *task.TaskArn, tailStop)
+		}()
+	}
+
+	d.Log("Waiting for task to stop...")
+	if err := d.ecs.WaitUntilTasksStoppedWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(d.Cluster),
+		Tasks:   []*string{task.TaskArn},
+	}); err != nil {
+		return errors.Wrap(err, "failed to wait for task to stop")
+	}
+
+	out2, err := d.ecs.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(d.Cluster),
+		Tasks:   []*string{task.TaskArn},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to describe task")
+	}
+
+	if tailStop != nil {
+		close(tailStop)
+		select {
+		case <-tailDone:
+		case <-time.After(logPollInterval + time.Second):
+			d.Log("timed out waiting for log tail to finish draining")
+		}
+	}
+	stopped := out2.Tasks[0]
+	switch aws.StringValue(stopped.StopCode) {
+	case ecs.TaskStopCodeEssentialContainerExited, ecs.TaskStopCodeUserInitiated, "":
+		// normal stop; exit status is carried by the containers, checked below.
+	default:
+		return fmt.Errorf("task stopped abnormally (%s): %s", aws.StringValue(stopped.StopCode), aws.StringValue(stopped.StoppedReason))
+	}
+	for _, c := range stopped.Containers {
+		if c.ExitCode == nil {
+			continue
+		}
+		if *c.ExitCode != 0 {
+			return fmt.Errorf("container %s exited with code %d: %s", aws.StringValue(c.Name), *c.ExitCode, aws.StringValue(c.Reason))
+		}
+	}
+	d.Log("Task stopped successfully")
+	return nil
+}
+
+// taskOverrideFor builds the container override for the target container
+// (or the first container in the task definition when unspecified) from the
+// command/environment/cpu/memory options passed to run-task.
+func taskOverrideFor(opt RunTaskOption) *ecs.TaskOverride {
+	co := &ecs.ContainerOverride{
+		Name: opt.ContainerName,
+	}
+	if opt.Command != nil && *opt.Command != "" {
+		co.Command = aws.StringSlice(strings.Fields(*opt.Command))
+	}
+	for k, v := range opt.Environment {
+		co.Environment = append(co.Environment, &ecs.KeyValuePair{
+			Name:  aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+	if opt.Cpu != nil {
+		co.Cpu = opt.Cpu
+	}
+	if opt.Memory != nil {
+		co.Memory = opt.Memory
+	}
+	return &ecs.TaskOverride{
+		ContainerOverrides: []*ecs.ContainerOverride{co},
+	}
+}
+
+// tailTaskLogs streams the awslogs group/stream for a running task to the
+// app's log output until the context is cancelled or stop is closed. On
+// stop it does one last synchronous fetch before returning, so the final
+// lines logged right before the task stops (e.g. a failure trace on a
+// non-zero exit) aren't dropped.
+func (d *App) tailTaskLogs(ctx context.Context, tdArn, containerName, taskArn string, stop <-chan struct{}) {
+	group, stream, err := d.logConfigurationFor(ctx, tdArn, containerName, taskArn)
+	if err != nil {
+		d.Log("failed to resolve log configuration:", err)
+		return
+	}
+
+	var nextToken *string
+	throttled := 0
+	fetch := func() bool {
+		out, err := d.cwlogs.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(group),
+			LogStreamName: aws.String(stream),
+			NextToken:     nextToken,
+			StartFromHead: aws.Bool(true),
+		})
+		if err != nil {
+			if isThrottled(err) && throttled < defaultRetryCount {
+				throttled++
+				d.DebugLog("GetLogEvents throttled, retrying:", err)
+				return true
+			}
+			d.Log("failed to get log events:", err)
+			return false
+		}
+		throttled = 0
+		for _, ev := range out.Events {
+			d.Log(aws.StringValue(ev.Message))
+		}
+		nextToken = out.NextForwardToken
+		return true
+	}
+
+	for {
+		if !fetch() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			fetch()
+			return
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// logConfigurationFor resolves the awslogs group and stream name for the
+// given container of a task, following the awslogs-stream-prefix/
+// container-name/task-id convention ECS uses to name log streams.
+func (d *App) logConfigurationFor(ctx context.Context, tdArn, containerName, taskArn string) (group, stream string, err error) {
+	out, err := d.ecs.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(tdArn),
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to describe task definition")
+	}
+	for _, c := range out.TaskDefinition.ContainerDefinitions {
+		if containerName != "" && aws.StringValue(c.Name) != containerName {
+			continue
+		}
+		lc := c.LogConfiguration
+		if lc == nil || aws.StringValue(lc.LogDriver) != "awslogs" {
+			continue
+		}
+		opts := lc.Options
+		group = aws.StringValue(opts["awslogs-group"])
+		prefix := aws.StringValue(opts["awslogs-stream-prefix"])
+		if group == "" || prefix == "" {
+			continue
+		}
+		parts := strings.Split(taskArn, "/")
+		taskID := parts[len(parts)-1]
+		return group, fmt.Sprintf("%s/%s/%s", prefix, aws.StringValue(c.Name), taskID), nil
+	}
+	return "", "", fmt.Errorf("no awslogs log configuration found for container %q", containerName)
+}
@@ -0,0 +1,306 @@
+package ecspresso
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/pkg/errors"
+)
+
+// verifyResult is one line of the preflight report: the thing that was
+// checked and whether it passed.
+type verifyResult struct {
+	Name string
+	OK   bool
+	Err  error
+}
+
+func (r verifyResult) String() string {
+	if r.OK {
+		return fmt.Sprintf("[OK] %s", r.Name)
+	}
+	return fmt.Sprintf("[NG] %s: %s", r.Name, r.Err)
+}
+
+// Verify resolves the task definition and, without mutating anything,
+// checks that it will actually start: roles exist and are assumable by
+// ECS, every ECR container image referenced by tag is pullable, every secret
+// is resolvable, every log group exists (optionally creating it), and every
+// load balancer's container port matches a port mapping of that container.
+// Images outside ECR and digest references are not checked, and the target
+// group's own health-check port is not looked up. It closes the class
+// of deploys that succeed at RegisterTaskDefinition but fail at task
+// startup.
+func (d *App) Verify(opt VerifyOption) error {
+	ctx, cancel := d.Start()
+	defer cancel()
+
+	d.Log("Starting verify")
+	td, err := d.LoadTaskDefinition(d.config.TaskDefinitionPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to load task definition")
+	}
+
+	var results []verifyResult
+	results = append(results, d.verifyRoles(ctx, td)...)
+	results = append(results, d.verifyImages(ctx, td)...)
+	results = append(results, d.verifySecrets(ctx, td)...)
+	results = append(results, d.verifyLogGroups(ctx, td, *opt.CreateLogGroups)...)
+
+	sv, err := d.DescribeServiceStatus(ctx, 0)
+	if err == nil {
+		results = append(results, d.verifyLoadBalancers(td, sv)...)
+	}
+
+	failed := 0
+	for _, r := range results {
+		d.Log(r.String())
+		if !r.OK {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("verify failed: %d of %d checks failed", failed, len(results))
+	}
+	d.Log("Verify OK")
+	return nil
+}
+
+func (d *App) verifyRoles(ctx context.Context, td *ecs.TaskDefinition) []verifyResult {
+	var results []verifyResult
+	check := func(label string, roleArn *string) {
+		if roleArn == nil || *roleArn == "" {
+			return
+		}
+		name := roleNameFromArn(*roleArn)
+		out, err := d.iam.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+		results = append(results, verifyResult{Name: fmt.Sprintf("%s role %s exists", label, *roleArn), OK: err == nil, Err: err})
+		if err != nil {
+			return
+		}
+		trusted := false
+		if out.Role.AssumeRolePolicyDocument != nil {
+			trusted, err = trustPolicyAllowsECSTasks(*out.Role.AssumeRolePolicyDocument)
+		}
+		if !trusted && err == nil {
+			err = fmt.Errorf("trust policy does not allow ecs-tasks.amazonaws.com to assume this role")
+		}
+		results = append(results, verifyResult{
+			Name: fmt.Sprintf("%s role %s trust policy allows ecs-tasks.amazonaws.com", label, *roleArn),
+			OK:   trusted,
+			Err:  err,
+		})
+	}
+	check("task", td.TaskRoleArn)
+	check("execution", td.ExecutionRoleArn)
+	return results
+}
+
+func (d *App) verifyImages(ctx context.Context, td *ecs.TaskDefinition) []verifyResult {
+	var results []verifyResult
+	for _, c := range td.ContainerDefinitions {
+		image := aws.StringValue(c.Image)
+		repo, tag, ok := parseECRImage(image)
+		if !ok {
+			// not an ECR image (e.g. Docker Hub); skip, we can't cheaply HEAD it without credentials.
+			continue
+		}
+		out, err := d.ecr.BatchGetImageWithContext(ctx, &ecr.BatchGetImageInput{
+			RepositoryName: aws.String(repo),
+			ImageIds: []*ecr.ImageIdentifier{
+				{ImageTag: aws.String(tag)},
+			},
+		})
+		ok = err == nil && len(out.Failures) == 0 && len(out.Images) > 0
+		if err == nil && !ok && len(out.Failures) > 0 {
+			err = fmt.Errorf("%s: %s", aws.StringValue(out.Failures[0].FailureCode), aws.StringValue(out.Failures[0].FailureReason))
+		}
+		results = append(results, verifyResult{
+			Name: fmt.Sprintf("container %s image %s is pullable", aws.StringValue(c.Name), image),
+			OK:   ok,
+			Err:  err,
+		})
+	}
+	return results
+}
+
+func (d *App) verifySecrets(ctx context.Context, td *ecs.TaskDefinition) []verifyResult {
+	var results []verifyResult
+	for _, c := range td.ContainerDefinitions {
+		for _, s := range c.Secrets {
+			name := fmt.Sprintf("container %s secret %s (%s)", aws.StringValue(c.Name), aws.StringValue(s.Name), aws.StringValue(s.ValueFrom))
+			var err error
+			if isSecretsManagerArn(aws.StringValue(s.ValueFrom)) {
+				_, err = d.secretsmanager.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{
+					SecretId: s.ValueFrom,
+				})
+			} else {
+				_, err = d.ssm.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+					Name: s.ValueFrom,
+				})
+			}
+			results = append(results, verifyResult{Name: name, OK: err == nil, Err: err})
+		}
+	}
+	return results
+}
+
+func (d *App) verifyLogGroups(ctx context.Context, td *ecs.TaskDefinition, create bool) []verifyResult {
+	var results []verifyResult
+	for _, c := range td.ContainerDefinitions {
+		lc := c.LogConfiguration
+		if lc == nil || aws.StringValue(lc.LogDriver) != "awslogs" {
+			continue
+		}
+		group := aws.StringValue(lc.Options["awslogs-group"])
+		if group == "" {
+			continue
+		}
+		out, err := d.cwlogs.DescribeLogGroupsWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
+			LogGroupNamePrefix: aws.String(group),
+		})
+		exists := err == nil && logGroupExists(out.LogGroups, group)
+		if !exists && create {
+			_, cErr := d.cwlogs.CreateLogGroupWithContext(ctx, &cloudwatchlogs.CreateLogGroupInput{
+				LogGroupName: aws.String(group),
+			})
+			exists = cErr == nil
+			err = cErr
+		}
+		results = append(results, verifyResult{
+			Name: fmt.Sprintf("container %s log group %s exists", aws.StringValue(c.Name), group),
+			OK:   exists,
+			Err:  err,
+		})
+	}
+	return results
+}
+
+func (d *App) verifyLoadBalancers(td *ecs.TaskDefinition, sv *ecs.Service) []verifyResult {
+	var results []verifyResult
+	for _, lb := range sv.LoadBalancers {
+		port := aws.Int64Value(lb.ContainerPort)
+		name := aws.StringValue(lb.ContainerName)
+		found := false
+		for _, c := range td.ContainerDefinitions {
+			if aws.StringValue(c.Name) != name {
+				continue
+			}
+			for _, pm := range c.PortMappings {
+				if aws.Int64Value(pm.ContainerPort) == port {
+					found = true
+				}
+			}
+		}
+		var err error
+		if !found {
+			err = fmt.Errorf("no portMappings entry for container %s port %d", name, port)
+		}
+		results = append(results, verifyResult{
+			Name: fmt.Sprintf("load balancer target %s:%d matches a portMappings entry", name, port),
+			OK:   found,
+			Err:  err,
+		})
+	}
+	return results
+}
+
+// roleNameFromArn extracts the role name from an IAM role ARN, e.g.
+// "arn:aws:iam::123456789012:role/ecsTaskExecutionRole" -> "ecsTaskExecutionRole".
+func roleNameFromArn(arn string) string {
+	parts := strings.Split(arn, "/")
+	return parts[len(parts)-1]
+}
+
+// parseECRImage splits an image URI into its ECR repository name and tag,
+// reporting ok=false for images that aren't hosted on ECR (e.g. Docker Hub).
+func parseECRImage(image string) (repo, tag string, ok bool) {
+	if !strings.Contains(image, ".dkr.ecr.") {
+		return "", "", false
+	}
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		return "", "", false
+	}
+	rest := image[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		// digest reference; BatchGetImage needs a tag, so we can't verify these cheaply.
+		return "", "", false
+	}
+	colon := strings.LastIndex(rest, ":")
+	if colon < 0 {
+		return rest, "latest", true
+	}
+	return rest[:colon], rest[colon+1:], true
+}
+
+// isSecretsManagerArn reports whether valueFrom refers to a Secrets Manager
+// secret rather than an SSM parameter.
+func isSecretsManagerArn(valueFrom string) bool {
+	return strings.Contains(valueFrom, ":secretsmanager:")
+}
+
+// logGroupExists reports whether groups (as returned by DescribeLogGroups,
+// which matches on prefix) contains an exact match for name.
+func logGroupExists(groups []*cloudwatchlogs.LogGroup, name string) bool {
+	for _, g := range groups {
+		if aws.StringValue(g.LogGroupName) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// iamPolicyDocument is the subset of an IAM policy document needed to check
+// who is allowed to assume a role.
+type iamPolicyDocument struct {
+	Statement []struct {
+		Effect    string `json:"Effect"`
+		Principal struct {
+			Service json.RawMessage `json:"Service"`
+		} `json:"Principal"`
+	} `json:"Statement"`
+}
+
+// trustPolicyAllowsECSTasks reports whether an IAM role's (URL-encoded)
+// AssumeRolePolicyDocument has an Allow statement naming the ecs-tasks
+// service principal.
+func trustPolicyAllowsECSTasks(encodedDoc string) (bool, error) {
+	raw, err := url.QueryUnescape(encodedDoc)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to decode trust policy")
+	}
+	var doc iamPolicyDocument
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return false, errors.Wrap(err, "failed to parse trust policy")
+	}
+	for _, s := range doc.Statement {
+		if s.Effect != "Allow" {
+			continue
+		}
+		var services []string
+		if err := json.Unmarshal(s.Principal.Service, &services); err != nil {
+			var service string
+			if err := json.Unmarshal(s.Principal.Service, &service); err != nil {
+				continue
+			}
+			services = []string{service}
+		}
+		for _, svc := range services {
+			if svc == "ecs-tasks.amazonaws.com" {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}